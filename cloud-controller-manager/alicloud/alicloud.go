@@ -0,0 +1,172 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"io"
+	"time"
+
+	"github.com/denverdino/aliyungo/common"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+const (
+	// ProviderName is the name of this cloud provider, as registered with
+	// kube-controller-manager.
+	ProviderName = "alicloud"
+
+	// DEFAULT_REGION is the region region() falls back to when the
+	// cloud-config sets none.
+	DEFAULT_REGION = common.Hangzhou
+
+	// KUBERNETES_ALICLOUD_IDENTITY is sent as the User-Agent on every
+	// aliyun API call so that requests from this controller are
+	// identifiable on the provider side.
+	KUBERNETES_ALICLOUD_IDENTITY = "Kubernetes.Alicloud"
+
+	defaultCacheExpiration = 2 * time.Minute
+)
+
+// CloudConfig is the configuration read from the --cloud-config file passed
+// to kube-controller-manager.
+type CloudConfig struct {
+	Global struct {
+		KubernetesClusterTag string
+
+		AccessKeyID     string `json:"accessKeyID"`
+		AccessKeySecret string `json:"accessKeySecret"`
+
+		Region    string `json:"region"`
+		VpcID     string `json:"vpcid"`
+		VswitchID string `json:"vswitchid"`
+		ZoneID    string `json:"zoneid"`
+
+		// MetadataProvider selects the IMetaData implementation NewMetaData
+		// builds, e.g. "ecs" (default), "file" or "env". See
+		// RegisterMetaDataProvider.
+		MetadataProvider string `json:"metadataProvider"`
+
+		// Regions lists every region the cross-region orphan-SLB GC sweep
+		// should scan; it is otherwise unused.
+		Regions []string `json:"regions"`
+		// EnableLoadBalancerGC opts into the periodic orphan-SLB sweep
+		// across Regions. Off by default.
+		EnableLoadBalancerGC bool `json:"enableLoadBalancerGC"`
+	}
+}
+
+// cfg holds the configuration parsed out of the --cloud-config file. It is
+// populated once by newAliCloud() and read by the rest of the package.
+var cfg = &CloudConfig{}
+
+// region returns the Alibaba Cloud region every SLB/EIP/ECS call in this
+// package operates against: cfg.Global.Region if the cloud-config set one,
+// falling back to DEFAULT_REGION so the controller still works with a
+// minimal config.
+func region() common.Region {
+	if cfg.Global.Region != "" {
+		return common.Region(cfg.Global.Region)
+	}
+	return DEFAULT_REGION
+}
+
+// Cloud is the implementation of cloudprovider.Interface for Alibaba Cloud.
+type Cloud struct {
+	clientMgr *ClientMgr
+
+	// endpointLister backs ENI-mode backend resolution; it is only set up
+	// once kube-controller-manager calls SetInformers.
+	endpointLister corelisters.EndpointsLister
+
+	// serviceLister backs the cross-region orphan-SLB GC sweep.
+	serviceLister corelisters.ServiceLister
+}
+
+func newAliCloud(config io.Reader) (cloudprovider.Interface, error) {
+	if config != nil {
+		if err := yaml.NewYAMLOrJSONDecoder(config, 4096).Decode(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := NewClientMgr(cfg.Global.AccessKeyID, cfg.Global.AccessKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(2).Infof("alicloud: cloud provider initialized, region=%s", region())
+
+	cloud := &Cloud{clientMgr: mgr}
+	cloud.startLoadBalancerGC(mgr.stop)
+	return cloud, nil
+}
+
+func init() {
+	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
+		return newAliCloud(config)
+	})
+}
+
+// ProviderName returns the cloud provider ID.
+func (c *Cloud) ProviderName() string {
+	return ProviderName
+}
+
+// HasClusterID returns true since every cluster managed by this provider is
+// expected to carry a cluster-id tag.
+func (c *Cloud) HasClusterID() bool {
+	return true
+}
+
+// LoadBalancer returns the LoadBalancer interface implementation for this
+// cloud, backed by the ClientMgr's LoadBalancerClient.
+func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return c, true
+}
+
+// Instances is unimplemented; this provider does not support node address
+// or existence lookups through the cloudprovider.Instances interface.
+func (c *Cloud) Instances() (cloudprovider.Instances, bool) {
+	return nil, false
+}
+
+// Zones is unimplemented; this provider does not support zone-aware
+// scheduling through the cloudprovider.Zones interface.
+func (c *Cloud) Zones() (cloudprovider.Zones, bool) {
+	return nil, false
+}
+
+// Clusters is unimplemented; this provider does not support the legacy
+// cloudprovider.Clusters interface.
+func (c *Cloud) Clusters() (cloudprovider.Clusters, bool) {
+	return nil, false
+}
+
+// Routes is unimplemented; this provider does not reconcile pod CIDR routes
+// through the cloudprovider.Routes interface.
+func (c *Cloud) Routes() (cloudprovider.Routes, bool) {
+	return nil, false
+}
+
+// Initialize is a no-op; this provider has no use for the controller
+// client builder or the informer-independent stop channel it's handed.
+func (c *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+}
+
+var _ cloudprovider.Interface = &Cloud{}
@@ -0,0 +1,835 @@
+package alicloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/metadata"
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/denverdino/aliyungo/util"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	climgr, err := NewMockClientMgr(&mockClientSLB{})
+	if climgr == nil || err != nil {
+		t.Logf("create climgr error!")
+		t.Fail()
+	}
+	//realSlbClient(keyid,keysecret)
+}
+
+func NewMockClientMgr(client ClientSLBSDK) (*ClientMgr, error) {
+	token := &TokenAuth{
+		auth: metadata.RoleAuth{
+			AccessKeyId:     "xxxxxxx",
+			AccessKeySecret: "yyyyyyyyyyyyyyyyyyyyy",
+		},
+		active: false,
+	}
+
+	mgr := &ClientMgr{
+		stop:  make(<-chan struct{}, 1),
+		token: token,
+		meta: metadata.NewMockMetaData(nil, func(resource string) (string, error) {
+			if strings.Contains(resource, metadata.REGION) {
+				return "region-test", nil
+			}
+			return "", errors.New("not found")
+		}),
+		loadbalancer: &LoadBalancerClient{
+			c: client,
+		},
+	}
+	return mgr, nil
+}
+
+func TestFindLoadBalancer(t *testing.T) {
+	ctx := context.Background()
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "service-test",
+			UID:         "abcdefghigklmnopqrstu",
+			Annotations: map[string]string{
+			//ServiceAnnotationLoadBalancerId: LOADBALANCER_ID,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Type: "LoadBalancer",
+		},
+	}
+
+	base := newBaseLoadbalancer()
+	mgr, _ := NewMockClientMgr(&mockClientSLB{
+		describeLoadBalancers: func(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error) {
+
+			if args.LoadBalancerId != "" {
+				base[0].LoadBalancerId = args.LoadBalancerId
+				return base, nil
+			}
+			if args.LoadBalancerName != "" {
+				base[0].LoadBalancerName = args.LoadBalancerName
+				return base, nil
+			} else {
+				return nil, errors.New("loadbalancerid or loadbanancername must be specified.\n")
+			}
+			return base, nil
+		},
+		describeLoadBalancerAttribute: func(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error) {
+			t.Logf("findloadbalancer, [%s]", loadBalancerId)
+			return loadbalancerAttrib(&base[0]), nil
+		},
+	})
+
+	// 1.
+	// user need to create new loadbalancer. did not specify any exist loadbalancer.
+	// Expected fallback to use service UID to generate slb .
+	exist, lb, err := mgr.loadbalancer.findLoadBalancer(ctx, service)
+	if err != nil || !exist {
+		t.Logf("1. user need to create new loadbalancer. did not specify any exist loadbalancer.")
+		t.Fatal("Test findLoadBalancer fail.")
+	}
+	t.Logf("find loadbalancer: with name , [%s]", lb.LoadBalancerName)
+	if lb.LoadBalancerName != cloudprovider.GetLoadBalancerName(service) {
+		t.Fatal("find loadbalancer fail. suppose to find by name.")
+	}
+
+	// 2.
+	// user need to use an exist loadbalancer through annotations
+	service.Annotations[ServiceAnnotationLoadBalancerId] = LOADBALANCER_ID + "-new"
+	exist, lb, err = mgr.loadbalancer.findLoadBalancer(ctx, service)
+	if err != nil || !exist {
+		t.Logf("2. user need to use an exist loadbalancer through annotations")
+		t.Fatal("Test findLoadBalancer fail.")
+	}
+	if lb.LoadBalancerId != LOADBALANCER_ID+"-new" {
+		t.Fatal("find loadbalancer fail. suppose to find by exist loadbalancerid.")
+	}
+
+	// 3.
+	// user has already create a loadbalancer. use ingress status`s id instead.
+	delete(service.Annotations, ServiceAnnotationLoadBalancerId)
+	ingress := v1.LoadBalancerIngress{
+		IP:       LOADBALANCER_ADDRESS,
+		Hostname: loadBalancerDomain("my-service", LOADBALANCER_ID+"-ingress", string(DEFAULT_REGION)),
+	}
+	service.Status.LoadBalancer.Ingress = append(service.Status.LoadBalancer.Ingress, ingress)
+	exist, lb, err = mgr.loadbalancer.findLoadBalancer(ctx, service)
+	if err != nil || !exist {
+		t.Logf("3.user has already create a loadbalancer. use ingress status`s id instead")
+		t.Fatal("Test findLoadBalancer fail.")
+	}
+	if lb.LoadBalancerId != LOADBALANCER_ID+"-ingress" {
+		t.Fatal("find loadbalancer fail. suppose to find by exist loadbalancerid.")
+	}
+}
+
+func TestFindLoadBalancerContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "service-test",
+			UID:       "abcdefghigklmnopqrstu",
+		},
+	}
+
+	mgr, _ := NewMockClientMgr(&realSLBClientStub{})
+	_, _, err := mgr.loadbalancer.findLoadBalancer(ctx, service)
+	if err == nil {
+		t.Fatal("expected findLoadBalancer to fail fast on a cancelled context")
+	}
+}
+
+// realSLBClientStub only exists to exercise ctx.Err() short-circuiting: like
+// realSLBClient, its DescribeLoadBalancers returns ctx.Err() before doing
+// anything else once the context is cancelled.
+type realSLBClientStub struct {
+	mockClientSLB
+}
+
+func (s *realSLBClientStub) DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("should not reach the backend once context is cancelled")
+}
+
+func TestBackendType(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	if bt := backendType(service); bt != BackendTypeECS {
+		t.Fatalf("expected default backend type %q, got %q", BackendTypeECS, bt)
+	}
+
+	service.Annotations[ServiceAnnotationLoadBalancerBackendType] = BackendTypeENI
+	if bt := backendType(service); bt != BackendTypeENI {
+		t.Fatalf("expected backend type %q, got %q", BackendTypeENI, bt)
+	}
+}
+
+func TestReconcileBackendsAddsAndRemovesStaleEntries(t *testing.T) {
+	ctx := context.Background()
+	var added []slb.BackendServerType
+	var removed []string
+	client := &mockClientSLB{
+		addBackendServers: func(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) ([]slb.BackendServerType, error) {
+			added = backendServers
+			return backendServers, nil
+		},
+		removeBackendServers: func(ctx context.Context, loadBalancerId string, backendServers []string) ([]slb.BackendServerType, error) {
+			removed = backendServers
+			return nil, nil
+		},
+	}
+
+	current := []slb.BackendServerType{
+		{ServerId: "eni-stale", ServerIp: "10.0.0.1", Type: BackendTypeENI, Weight: 100},
+		{ServerId: "eni-keep", ServerIp: "10.0.0.2", Type: BackendTypeENI, Weight: 100},
+	}
+	desired := []slb.BackendServerType{
+		{ServerId: "eni-keep", ServerIp: "10.0.0.2", Type: BackendTypeENI, Weight: 100},
+		{ServerId: "eni-new", ServerIp: "10.0.0.3", Type: BackendTypeENI, Weight: 100},
+	}
+
+	if err := reconcileBackends(ctx, client, LOADBALANCER_ID, current, desired); err != nil {
+		t.Fatalf("reconcileBackends failed: %v", err)
+	}
+	if len(added) != 1 || added[0].ServerId != "eni-new" {
+		t.Fatalf("expected only eni-new to be added, got %+v", added)
+	}
+	if len(removed) != 1 || removed[0] != "eni-stale" {
+		t.Fatalf("expected only eni-stale to be removed, got %+v", removed)
+	}
+}
+
+func TestReconcileTCPListener(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	client := &mockClientSLB{
+		describeLoadBalancerTCPListenerAttribute: func(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerTCPListenerAttributeResponse, error) {
+			return &slb.DescribeLoadBalancerTCPListenerAttributeResponse{
+				Scheduler: SchedulerRR,
+			}, nil
+		},
+		setLoadBalancerTCPListenerAttribute: func(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) error {
+			called = true
+			if args.Scheduler != SchedulerWRR {
+				t.Fatalf("expected scheduler %q, got %q", SchedulerWRR, args.Scheduler)
+			}
+			return nil
+		},
+	}
+
+	hc := desiredListenerHealthCheck(&v1.Service{})
+	if err := reconcileTCPListener(ctx, client, LOADBALANCER_ID, 80, hc); err != nil {
+		t.Fatalf("reconcileTCPListener failed: %v", err)
+	}
+	if !called {
+		t.Fatal("expected SetLoadBalancerTCPListenerAttribute to be called when scheduler drifted")
+	}
+}
+
+func TestReconcileListenersCreatesMissingListener(t *testing.T) {
+	ctx := context.Background()
+	created := false
+	started := false
+	client := &mockClientSLB{
+		createLoadBalancerTCPListener: func(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) error {
+			created = true
+			if args.ListenerPort != 80 || args.BackendServerPort != 30080 {
+				t.Fatalf("unexpected listener args: %+v", args)
+			}
+			return nil
+		},
+		startLoadBalancerListener: func(ctx context.Context, loadBalancerId string, port int) error {
+			started = true
+			return nil
+		},
+	}
+
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}}},
+	}
+	lb := &slb.LoadBalancerType{LoadBalancerId: LOADBALANCER_ID}
+	if err := reconcileListeners(ctx, client, LOADBALANCER_ID, lb, service); err != nil {
+		t.Fatalf("reconcileListeners failed: %v", err)
+	}
+	if !created || !started {
+		t.Fatal("expected a missing listener to be created and started")
+	}
+}
+
+func TestReconcileListenersUpdatesExistingHTTPListener(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	client := &mockClientSLB{
+		setLoadBalancerHTTPListenerAttribute: func(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) error {
+			called = true
+			if args.ListenerPort != 80 {
+				t.Fatalf("unexpected listener port: %d", args.ListenerPort)
+			}
+			return nil
+		},
+	}
+
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP}}},
+	}
+	lb := &slb.LoadBalancerType{
+		LoadBalancerId: LOADBALANCER_ID,
+		ListenerPortsAndProtocol: slb.ListenerPortAndProtocolType{
+			ListenerPortAndProtocol: []string{"80:http"},
+		},
+	}
+	if err := reconcileListeners(ctx, client, LOADBALANCER_ID, lb, service); err != nil {
+		t.Fatalf("reconcileListeners failed: %v", err)
+	}
+	if !called {
+		t.Fatal("expected SetLoadBalancerHTTPListenerAttribute to be called for an existing http listener")
+	}
+}
+
+func TestExistingListenerProtocols(t *testing.T) {
+	lb := &slb.LoadBalancerType{
+		ListenerPortsAndProtocol: slb.ListenerPortAndProtocolType{
+			ListenerPortAndProtocol: []string{"80:http", "443:https", "not-a-port"},
+		},
+	}
+	existing := existingListenerProtocols(lb)
+	if existing[80] != "http" || existing[443] != "https" {
+		t.Fatalf("unexpected existing listener protocols: %+v", existing)
+	}
+	if len(existing) != 2 {
+		t.Fatalf("expected malformed entries to be skipped, got %+v", existing)
+	}
+}
+
+func TestEnsureLoadBalancerSetsRecoverableHostname(t *testing.T) {
+	ctx := context.Background()
+	mgr, _ := NewMockClientMgr(&mockClientSLB{
+		describeLoadBalancers: func(ctx context.Context, args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+			return nil, nil
+		},
+	})
+	cloud := &Cloud{clientMgr: mgr}
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service-test", UID: "abcdefghigklmnopqrstu"},
+		Spec:       v1.ServiceSpec{Type: "LoadBalancer"},
+	}
+
+	status, err := cloud.EnsureLoadBalancer(ctx, "cluster", service, nil)
+	if err != nil {
+		t.Fatalf("EnsureLoadBalancer failed: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].Hostname == "" {
+		t.Fatal("expected EnsureLoadBalancer to set a recoverable hostname on the ingress status")
+	}
+
+	// This is what lets the orphan-SLB GC sweep (sweepOrphanLoadBalancers)
+	// tell a live, ordinary Service apart from an orphan: without it,
+	// resolveLoadBalancerId has nothing to go on for a Service that never
+	// set the id annotation.
+	service.Status.LoadBalancer = *status
+	if id := resolveLoadBalancerId(service); id != LOADBALANCER_ID {
+		t.Fatalf("expected resolveLoadBalancerId to recover %q from the ingress hostname, got %q", LOADBALANCER_ID, id)
+	}
+}
+
+func TestOwnedByCluster(t *testing.T) {
+	tags := []slb.TagItemType{{TagKey: clusterIdTagKey, TagValue: "cluster-a"}}
+	if !ownedByCluster(tags, "cluster-a") {
+		t.Fatal("expected tags to be recognized as owned by cluster-a")
+	}
+	if ownedByCluster(tags, "cluster-b") {
+		t.Fatal("tags for cluster-a must not be recognized as owned by cluster-b")
+	}
+	if ownedByCluster(tags, "") {
+		t.Fatal("an empty cluster id must never match")
+	}
+}
+
+type mockClientECSEIP struct {
+	allocateEipAddress    func(ctx context.Context, args *ecs.AllocateEipAddressArgs) (*ecs.AllocateEipAddressResponse, error)
+	associateEipAddress   func(ctx context.Context, allocationId, instanceId string) error
+	unassociateEipAddress func(ctx context.Context, allocationId, instanceId string) error
+	releaseEipAddress     func(ctx context.Context, allocationId string) error
+	describeEipAddresses  func(ctx context.Context, args *ecs.DescribeEipAddressesArgs) ([]ecs.EipAddressSetType, error)
+	addTags               func(ctx context.Context, args *ecs.AddTagsArgs) error
+	describeTags          func(ctx context.Context, args *ecs.DescribeTagsArgs) ([]ecs.TagItemType, error)
+}
+
+func (m *mockClientECSEIP) AllocateEipAddress(ctx context.Context, args *ecs.AllocateEipAddressArgs) (*ecs.AllocateEipAddressResponse, error) {
+	return m.allocateEipAddress(ctx, args)
+}
+func (m *mockClientECSEIP) AssociateEipAddress(ctx context.Context, allocationId, instanceId string) error {
+	return m.associateEipAddress(ctx, allocationId, instanceId)
+}
+func (m *mockClientECSEIP) UnassociateEipAddress(ctx context.Context, allocationId, instanceId string) error {
+	if m.unassociateEipAddress != nil {
+		return m.unassociateEipAddress(ctx, allocationId, instanceId)
+	}
+	return nil
+}
+func (m *mockClientECSEIP) ReleaseEipAddress(ctx context.Context, allocationId string) error {
+	if m.releaseEipAddress != nil {
+		return m.releaseEipAddress(ctx, allocationId)
+	}
+	return nil
+}
+func (m *mockClientECSEIP) DescribeEipAddresses(ctx context.Context, args *ecs.DescribeEipAddressesArgs) ([]ecs.EipAddressSetType, error) {
+	return m.describeEipAddresses(ctx, args)
+}
+func (m *mockClientECSEIP) AddTags(ctx context.Context, args *ecs.AddTagsArgs) error {
+	if m.addTags != nil {
+		return m.addTags(ctx, args)
+	}
+	return nil
+}
+func (m *mockClientECSEIP) DescribeTags(ctx context.Context, args *ecs.DescribeTagsArgs) ([]ecs.TagItemType, error) {
+	return m.describeTags(ctx, args)
+}
+
+func TestEnsureEIPAllocatesTagsAssociatesThenRecoversOnSecondReconcile(t *testing.T) {
+	ctx := context.Background()
+	const allocationId = "eip-1234"
+	const eipAddress = "47.97.1.1"
+	const loadBalancerId = LOADBALANCER_ID
+
+	var tagged *ecs.AddTagsArgs
+	var associated bool
+	eip := &mockClientECSEIP{
+		describeEipAddresses: func(ctx context.Context, args *ecs.DescribeEipAddressesArgs) ([]ecs.EipAddressSetType, error) {
+			if args.AssociatedInstanceId == loadBalancerId {
+				if !associated {
+					return nil, nil
+				}
+				return []ecs.EipAddressSetType{{AllocationId: allocationId, IpAddress: eipAddress, InstanceId: loadBalancerId}}, nil
+			}
+			if args.AllocationId == allocationId {
+				instanceId := ""
+				if associated {
+					instanceId = loadBalancerId
+				}
+				return []ecs.EipAddressSetType{{AllocationId: allocationId, IpAddress: eipAddress, InstanceId: instanceId}}, nil
+			}
+			return nil, fmt.Errorf("unexpected DescribeEipAddresses args: %+v", args)
+		},
+		allocateEipAddress: func(ctx context.Context, args *ecs.AllocateEipAddressArgs) (*ecs.AllocateEipAddressResponse, error) {
+			return &ecs.AllocateEipAddressResponse{AllocationId: allocationId}, nil
+		},
+		addTags: func(ctx context.Context, args *ecs.AddTagsArgs) error {
+			tagged = args
+			return nil
+		},
+		associateEipAddress: func(ctx context.Context, gotAllocationId, gotInstanceId string) error {
+			if gotAllocationId != allocationId || gotInstanceId != loadBalancerId {
+				t.Fatalf("unexpected AssociateEipAddress call: %s %s", gotAllocationId, gotInstanceId)
+			}
+			associated = true
+			return nil
+		},
+	}
+
+	cloud := &Cloud{clientMgr: &ClientMgr{eip: &EIPClient{c: eip}}}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerAllocateEIP: "true"},
+		},
+	}
+
+	address, err := cloud.ensureEIP(ctx, service, loadBalancerId)
+	if err != nil {
+		t.Fatalf("ensureEIP failed: %v", err)
+	}
+	if address != eipAddress {
+		t.Fatalf("expected address %q, got %q", eipAddress, address)
+	}
+	if tagged == nil || tagged.ResourceId != allocationId || tagged.ResourceType != ecs.TagResourceEip {
+		t.Fatalf("expected the allocated eip to be tagged as controller-owned, got %+v", tagged)
+	}
+	if !associated {
+		t.Fatal("expected the allocated eip to be associated with the loadbalancer")
+	}
+
+	// Second reconcile: the eip is already associated, so ensureEIP should
+	// recover it through the AssociatedInstanceId lookup instead of
+	// allocating and tagging a new one.
+	tagged = nil
+	address, err = cloud.ensureEIP(ctx, service, loadBalancerId)
+	if err != nil {
+		t.Fatalf("second ensureEIP failed: %v", err)
+	}
+	if address != eipAddress {
+		t.Fatalf("expected recovered address %q, got %q", eipAddress, address)
+	}
+	if tagged != nil {
+		t.Fatal("expected second reconcile to recover the existing eip without allocating/tagging a new one")
+	}
+}
+
+func TestReleaseControllerOwnedEIPSkipsUntaggedEIP(t *testing.T) {
+	ctx := context.Background()
+	const loadBalancerId = LOADBALANCER_ID
+	const ownedAllocationId = "eip-owned"
+	const broughtAllocationId = "eip-brought"
+
+	var released []string
+	eip := &mockClientECSEIP{
+		describeEipAddresses: func(ctx context.Context, args *ecs.DescribeEipAddressesArgs) ([]ecs.EipAddressSetType, error) {
+			return []ecs.EipAddressSetType{
+				{AllocationId: ownedAllocationId, InstanceId: loadBalancerId},
+				{AllocationId: broughtAllocationId, InstanceId: loadBalancerId},
+			}, nil
+		},
+		describeTags: func(ctx context.Context, args *ecs.DescribeTagsArgs) ([]ecs.TagItemType, error) {
+			if args.ResourceId == ownedAllocationId {
+				return []ecs.TagItemType{{TagKey: eipOwnerTagKey, TagValue: eipOwnerTagValue}}, nil
+			}
+			return nil, nil
+		},
+		releaseEipAddress: func(ctx context.Context, allocationId string) error {
+			released = append(released, allocationId)
+			return nil
+		},
+	}
+
+	cloud := &Cloud{clientMgr: &ClientMgr{eip: &EIPClient{c: eip}}}
+	if err := cloud.releaseControllerOwnedEIP(ctx, loadBalancerId); err != nil {
+		t.Fatalf("releaseControllerOwnedEIP failed: %v", err)
+	}
+	if len(released) != 1 || released[0] != ownedAllocationId {
+		t.Fatalf("expected only the controller-tagged eip %q to be released, got %+v", ownedAllocationId, released)
+	}
+}
+
+func TestSweepOrphanLoadBalancersDeletesOnlyOrphans(t *testing.T) {
+	ctx := context.Background()
+	const clusterID = "cluster-a"
+	liveId := LOADBALANCER_ID
+	orphanId := LOADBALANCER_ID + "-orphan"
+
+	cfg.Global.KubernetesClusterTag = clusterID
+	cfg.Global.Regions = []string{"cn-hangzhou"}
+	defer func() {
+		cfg.Global.KubernetesClusterTag = ""
+		cfg.Global.Regions = nil
+	}()
+
+	liveService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "live"},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{Hostname: loadBalancerDomain("live", liveId, string(DEFAULT_REGION))}},
+			},
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := indexer.Add(liveService); err != nil {
+		t.Fatalf("failed to seed service indexer: %v", err)
+	}
+
+	var deleted []string
+	client := &mockClientSLB{
+		describeLoadBalancers: func(ctx context.Context, args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+			return []slb.LoadBalancerType{{LoadBalancerId: liveId}, {LoadBalancerId: orphanId}}, nil
+		},
+		describeTags: func(ctx context.Context, args *slb.DescribeTagsArgs) ([]slb.TagItemType, error) {
+			return []slb.TagItemType{{TagKey: clusterIdTagKey, TagValue: clusterID}}, nil
+		},
+		deleteLoadBalancer: func(ctx context.Context, loadBalancerId string) error {
+			deleted = append(deleted, loadBalancerId)
+			return nil
+		},
+	}
+
+	mgr, _ := NewMockClientMgr(client)
+	cloud := &Cloud{clientMgr: mgr, serviceLister: corelisters.NewServiceLister(indexer)}
+
+	cloud.sweepOrphanLoadBalancers(ctx, flowcontrol.NewFakeAlwaysRateLimiter())
+
+	if len(deleted) != 1 || deleted[0] != orphanId {
+		t.Fatalf("expected only the orphan %q to be deleted, got %+v", orphanId, deleted)
+	}
+}
+
+func TestEnvMetaDataProvider(t *testing.T) {
+	os.Setenv("ALICLOUD_META_REGION", "cn-hangzhou")
+	defer os.Unsetenv("ALICLOUD_META_REGION")
+
+	m := &envMetaData{}
+	region, err := m.Region()
+	if err != nil || region != "cn-hangzhou" {
+		t.Fatalf("expected region cn-hangzhou, got %q, err=%v", region, err)
+	}
+
+	if _, err := m.Zone(); err == nil {
+		t.Fatal("expected an error for an unset ALICLOUD_META_ZONE")
+	}
+}
+
+func TestNewMetaDataUnknownProviderFallsBackToECS(t *testing.T) {
+	cfg.Global.MetadataProvider = "bogus"
+	defer func() { cfg.Global.MetadataProvider = "" }()
+
+	if _, ok := NewMetaData().(*metadata.MetaData); !ok {
+		t.Fatal("expected NewMetaData to fall back to the ecs provider instead of returning nil")
+	}
+}
+
+func realSlbClient(keyid, keysec string) {
+
+	slbclient := slb.NewClient(keyid, keysec)
+	slbclient.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
+	lb, err := slbclient.DescribeLoadBalancers(&slb.DescribeLoadBalancersArgs{
+		RegionId:       common.Hangzhou,
+		LoadBalancerId: "lb-bp1ids9hmq5924m6uk5w1",
+	})
+	if err == nil {
+		a, _ := json.Marshal(lb)
+		var prettyJSON bytes.Buffer
+		err = json.Indent(&prettyJSON, a, "", "    ")
+		fmt.Printf(string(prettyJSON.Bytes()))
+	}
+	lbs, err := slbclient.DescribeLoadBalancerAttribute(LOADBALANCER_ID)
+	if err == nil {
+		a, _ := json.Marshal(lbs)
+		var prettyJSON bytes.Buffer
+		err = json.Indent(&prettyJSON, a, "", "    ")
+		fmt.Printf(string(prettyJSON.Bytes()))
+	}
+	listener, err := slbclient.DescribeLoadBalancerTCPListenerAttribute(LOADBALANCER_ID, 80)
+	if err == nil {
+		a, _ := json.Marshal(listener)
+		var prettyJSON bytes.Buffer
+		err = json.Indent(&prettyJSON, a, "", "    ")
+		fmt.Printf(string(prettyJSON.Bytes()))
+	}
+}
+
+type mockClientSLB struct {
+	describeLoadBalancers          func(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error)
+	createLoadBalancer             func(ctx context.Context, args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error)
+	deleteLoadBalancer             func(ctx context.Context, loadBalancerId string) (err error)
+	modifyLoadBalancerInternetSpec func(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) (err error)
+	describeLoadBalancerAttribute  func(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error)
+	removeBackendServers           func(ctx context.Context, loadBalancerId string, backendServers []string) (result []slb.BackendServerType, err error)
+	addBackendServers              func(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
+
+	stopLoadBalancerListener                   func(ctx context.Context, loadBalancerId string, port int) (err error)
+	startLoadBalancerListener                  func(ctx context.Context, loadBalancerId string, port int) (err error)
+	createLoadBalancerTCPListener              func(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) (err error)
+	createLoadBalancerUDPListener              func(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) (err error)
+	deleteLoadBalancerListener                 func(ctx context.Context, loadBalancerId string, port int) (err error)
+	createLoadBalancerHTTPSListener            func(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) (err error)
+	createLoadBalancerHTTPListener             func(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) (err error)
+	describeLoadBalancerHTTPSListenerAttribute func(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, err error)
+	describeLoadBalancerTCPListenerAttribute   func(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerTCPListenerAttributeResponse, err error)
+	describeLoadBalancerUDPListenerAttribute   func(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerUDPListenerAttributeResponse, err error)
+	describeLoadBalancerHTTPListenerAttribute  func(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPListenerAttributeResponse, err error)
+
+	setLoadBalancerTCPListenerAttribute   func(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) (err error)
+	setLoadBalancerUDPListenerAttribute   func(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) (err error)
+	setLoadBalancerHTTPListenerAttribute  func(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) (err error)
+	setLoadBalancerHTTPSListenerAttribute func(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) (err error)
+
+	addTags      func(ctx context.Context, args *slb.AddTagsArgs) (err error)
+	describeTags func(ctx context.Context, args *slb.DescribeTagsArgs) (tags []slb.TagItemType, err error)
+}
+
+var (
+	LOADBALANCER_ID           = "lb-bp1ids9hmq5924m6uk5w1"
+	LOADBALANCER_NAME         = "a594334ad276811e8a62700163e10c02"
+	LOADBALANCER_ADDRESS      = "47.97.241.114"
+	LOADBALANCER_NETWORK_TYPE = "classic"
+)
+
+func newBaseLoadbalancer() []slb.LoadBalancerType {
+	return []slb.LoadBalancerType{
+		{
+			LoadBalancerId:     LOADBALANCER_ID,
+			LoadBalancerName:   LOADBALANCER_NAME,
+			LoadBalancerStatus: "active",
+			Address:            LOADBALANCER_ADDRESS,
+			RegionId:           "cn-hangzhou",
+			RegionIdAlias:      "cn-hangzhou",
+			AddressType:        "internet",
+			VSwitchId:          "",
+			VpcId:              "",
+			NetworkType:        LOADBALANCER_NETWORK_TYPE,
+			Bandwidth:          0,
+			InternetChargeType: "4",
+			CreateTime:         "2018-03-14T17:16Z",
+			CreateTimeStamp:    util.NewISO6801Time(time.Now()),
+		},
+	}
+}
+
+func (c *mockClientSLB) DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error) {
+	if c.describeLoadBalancers != nil {
+		return c.describeLoadBalancers(ctx, args)
+	}
+	return newBaseLoadbalancer(), nil
+}
+
+func (c *mockClientSLB) StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if c.stopLoadBalancerListener != nil {
+		return c.stopLoadBalancerListener(ctx, loadBalancerId, port)
+	}
+	// return nil indicate no stop success
+	return nil
+}
+
+func (c *mockClientSLB) CreateLoadBalancer(ctx context.Context, args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error) {
+	if c.createLoadBalancer != nil {
+		return c.createLoadBalancer(ctx, args)
+	}
+	return &slb.CreateLoadBalancerResponse{
+		LoadBalancerId:   LOADBALANCER_ID,
+		Address:          LOADBALANCER_ADDRESS,
+		NetworkType:      LOADBALANCER_NETWORK_TYPE,
+		LoadBalancerName: LOADBALANCER_NAME,
+	}, nil
+}
+func (c *mockClientSLB) DeleteLoadBalancer(ctx context.Context, loadBalancerId string) (err error) {
+	if c.deleteLoadBalancer != nil {
+		return c.deleteLoadBalancer(ctx, loadBalancerId)
+	}
+	return nil
+}
+func (c *mockClientSLB) ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) (err error) {
+	if c.modifyLoadBalancerInternetSpec != nil {
+		return c.modifyLoadBalancerInternetSpec(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error) {
+	if c.describeLoadBalancerAttribute != nil {
+		return c.describeLoadBalancerAttribute(ctx, loadBalancerId)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []string) (result []slb.BackendServerType, err error) {
+	if c.removeBackendServers != nil {
+		return c.removeBackendServers(ctx, loadBalancerId, backendServers)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error) {
+	if c.addBackendServers != nil {
+		return c.addBackendServers(ctx, loadBalancerId, backendServers)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if c.startLoadBalancerListener != nil {
+		return c.startLoadBalancerListener(ctx, loadBalancerId, port)
+	}
+	return nil
+}
+func (c *mockClientSLB) CreateLoadBalancerTCPListener(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) (err error) {
+	if c.createLoadBalancerTCPListener != nil {
+		return c.createLoadBalancerTCPListener(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) CreateLoadBalancerUDPListener(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) (err error) {
+	if c.createLoadBalancerUDPListener != nil {
+		return c.createLoadBalancerUDPListener(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error) {
+	if c.deleteLoadBalancerListener != nil {
+		return c.deleteLoadBalancerListener(ctx, loadBalancerId, port)
+	}
+	return nil
+}
+func (c *mockClientSLB) CreateLoadBalancerHTTPSListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) (err error) {
+	if c.createLoadBalancerHTTPSListener != nil {
+		return c.createLoadBalancerHTTPSListener(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) CreateLoadBalancerHTTPListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) (err error) {
+	if c.createLoadBalancerHTTPListener != nil {
+		return c.createLoadBalancerHTTPListener(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) DescribeLoadBalancerHTTPSListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, err error) {
+	if c.describeLoadBalancerHTTPSListenerAttribute != nil {
+		return c.describeLoadBalancerHTTPSListenerAttribute(ctx, loadBalancerId, port)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) DescribeLoadBalancerTCPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerTCPListenerAttributeResponse, err error) {
+	if c.describeLoadBalancerTCPListenerAttribute != nil {
+		return c.describeLoadBalancerTCPListenerAttribute(ctx, loadBalancerId, port)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) DescribeLoadBalancerUDPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerUDPListenerAttributeResponse, err error) {
+	if c.describeLoadBalancerUDPListenerAttribute != nil {
+		return c.describeLoadBalancerUDPListenerAttribute(ctx, loadBalancerId, port)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) DescribeLoadBalancerHTTPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPListenerAttributeResponse, err error) {
+	if c.describeLoadBalancerHTTPListenerAttribute != nil {
+		return c.describeLoadBalancerHTTPListenerAttribute(ctx, loadBalancerId, port)
+	}
+	return nil, nil
+}
+func (c *mockClientSLB) SetLoadBalancerTCPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) (err error) {
+	if c.setLoadBalancerTCPListenerAttribute != nil {
+		return c.setLoadBalancerTCPListenerAttribute(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) SetLoadBalancerUDPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) (err error) {
+	if c.setLoadBalancerUDPListenerAttribute != nil {
+		return c.setLoadBalancerUDPListenerAttribute(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) SetLoadBalancerHTTPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) (err error) {
+	if c.setLoadBalancerHTTPListenerAttribute != nil {
+		return c.setLoadBalancerHTTPListenerAttribute(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) SetLoadBalancerHTTPSListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) (err error) {
+	if c.setLoadBalancerHTTPSListenerAttribute != nil {
+		return c.setLoadBalancerHTTPSListenerAttribute(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) AddTags(ctx context.Context, args *slb.AddTagsArgs) (err error) {
+	if c.addTags != nil {
+		return c.addTags(ctx, args)
+	}
+	return nil
+}
+func (c *mockClientSLB) DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) (tags []slb.TagItemType, err error) {
+	if c.describeTags != nil {
+		return c.describeTags(ctx, args)
+	}
+	return nil, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// ServiceAnnotationLoadBalancerBackendType selects how backend servers are
+// registered with the SLB instance for a Service.
+const ServiceAnnotationLoadBalancerBackendType = "service.beta.kubernetes.io/alicloud-loadbalancer-backend-type"
+
+const (
+	// BackendTypeECS registers node ECS instance ids as backends. This is
+	// the default, and relies on kube-proxy to forward traffic from the
+	// node onward to the right pod.
+	BackendTypeECS = "ecs"
+
+	// BackendTypeENI registers the ENI a Service's endpoint pod IPs are
+	// attached to directly as SLB backends, bypassing kube-proxy.
+	BackendTypeENI = "eni"
+)
+
+// backendType returns the backend mode requested for service, defaulting to
+// BackendTypeECS.
+func backendType(service *v1.Service) string {
+	if t, ok := service.Annotations[ServiceAnnotationLoadBalancerBackendType]; ok && t != "" {
+		return t
+	}
+	return BackendTypeECS
+}
+
+// SetInformers wires up the endpoints lister ENI-mode backend resolution
+// depends on. It implements cloudprovider.InformerUser, which
+// kube-controller-manager calls on startup for any cloud provider that asks
+// for it.
+func (c *Cloud) SetInformers(informerFactory informers.SharedInformerFactory) {
+	c.endpointLister = informerFactory.Core().V1().Endpoints().Lister()
+	c.serviceLister = informerFactory.Core().V1().Services().Lister()
+}
+
+// eniBackends resolves the ENI ids backing a Service's endpoint pod IPs into
+// the SLB backend server set for ENI-mode load balancing.
+func (c *Cloud) eniBackends(ctx context.Context, service *v1.Service) ([]slb.BackendServerType, error) {
+	if c.endpointLister == nil {
+		return nil, fmt.Errorf("alicloud: endpoints informer not wired up, cannot resolve eni backends for service %s/%s", service.Namespace, service.Name)
+	}
+
+	endpoints, err := c.endpointLister.Endpoints(service.Namespace).Get(service.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	podIPs := sets.NewString()
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			podIPs.Insert(addr.IP)
+		}
+	}
+	if podIPs.Len() == 0 {
+		return nil, nil
+	}
+
+	nics, err := c.clientMgr.Instances().DescribeNetworkInterfaces(ctx, &ecs.DescribeNetworkInterfacesArgs{
+		RegionId:         region(),
+		PrivateIpAddress: podIPs.List(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make([]slb.BackendServerType, 0, podIPs.Len())
+	for _, nic := range nics {
+		for _, ip := range nic.PrivateIpSets.PrivateIpSet {
+			if !podIPs.Has(ip.PrivateIpAddress) {
+				continue
+			}
+			backends = append(backends, slb.BackendServerType{
+				ServerId: nic.NetworkInterfaceId,
+				ServerIp: ip.PrivateIpAddress,
+				Weight:   100,
+				Type:     BackendTypeENI,
+			})
+			glog.V(4).Infof("alicloud: resolved eni backend %s (%s) for service %s/%s", nic.NetworkInterfaceId, ip.PrivateIpAddress, service.Namespace, service.Name)
+		}
+	}
+	return backends, nil
+}
+
+// ecsBackends registers the nodes' ECS instance ids as SLB backends, the
+// default kube-proxy-forwarded mode.
+func ecsBackends(nodes []*v1.Node) []slb.BackendServerType {
+	backends := make([]slb.BackendServerType, 0, len(nodes))
+	for _, node := range nodes {
+		backends = append(backends, slb.BackendServerType{
+			ServerId: node.Name,
+			Weight:   100,
+			Type:     BackendTypeECS,
+		})
+	}
+	return backends
+}
+
+// backendKey identifies a backend registration by the (serverId, serverIp)
+// tuple it was registered under - for ENI-mode backends serverId is the ENI
+// id and serverIp the pod IP it's carrying, so the same ENI re-registered
+// for a different pod IP is treated as a distinct entry; for ECS-mode
+// backends serverIp is always empty and the key collapses to the instance
+// id.
+func backendKey(b slb.BackendServerType) string {
+	return b.ServerId + "/" + b.ServerIp
+}
+
+// reconcileBackends diffs the SLB's current backend set against the
+// desired one, adding what's missing and removing what's stale - without
+// the removal step, a scaled-down node or a rescheduled ENI pod leaves a
+// dead backend registered on the SLB forever. RemoveBackendServers takes
+// server ids only, so removing a stale entry drops every entry registered
+// under that id; desired entries sharing such an id are added back.
+func reconcileBackends(ctx context.Context, client ClientSLBSDK, loadBalancerId string, current, desired []slb.BackendServerType) error {
+	currentKeys := sets.NewString()
+	for _, b := range current {
+		currentKeys.Insert(backendKey(b))
+	}
+	desiredKeys := sets.NewString()
+	for _, b := range desired {
+		desiredKeys.Insert(backendKey(b))
+	}
+
+	removedIds := sets.NewString()
+	var toRemove []string
+	for _, b := range current {
+		if !desiredKeys.Has(backendKey(b)) && !removedIds.Has(b.ServerId) {
+			removedIds.Insert(b.ServerId)
+			toRemove = append(toRemove, b.ServerId)
+		}
+	}
+	var toAdd []slb.BackendServerType
+	for _, b := range desired {
+		if !currentKeys.Has(backendKey(b)) || removedIds.Has(b.ServerId) {
+			toAdd = append(toAdd, b)
+		}
+	}
+
+	if len(toRemove) > 0 {
+		if _, err := client.RemoveBackendServers(ctx, loadBalancerId, toRemove); err != nil {
+			return err
+		}
+	}
+	if len(toAdd) > 0 {
+		if _, err := client.AddBackendServers(ctx, loadBalancerId, toAdd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ cloudprovider.InformerUser = &Cloud{}
@@ -26,8 +26,6 @@ import (
 	"github.com/golang/glog"
 	"github.com/patrickmn/go-cache"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"fmt"
-	"strings"
 )
 
 var ROLE_NAME = "KubernetesMasterRole"
@@ -58,6 +56,7 @@ type ClientMgr struct {
 	routes       *RoutesClient
 	loadbalancer *LoadBalancerClient
 	instance     *InstanceClient
+	eip          *EIPClient
 }
 
 func NewClientMgr(key, secret string) (*ClientMgr, error) {
@@ -85,9 +84,9 @@ func NewClientMgr(key, secret string) (*ClientMgr, error) {
 		}
 	}
 	keyid, sec, tok := token.authid()
-	ecsclient := ecs.NewECSClientWithSecurityToken(keyid, sec, tok, DEFAULT_REGION)
+	ecsclient := ecs.NewECSClientWithSecurityToken(keyid, sec, tok, region())
 	ecsclient.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
-	slbclient := slb.NewSLBClientWithSecurityToken(keyid, sec, tok, DEFAULT_REGION)
+	slbclient := slb.NewSLBClientWithSecurityToken(keyid, sec, tok, region())
 	slbclient.SetUserAgent(KUBERNETES_ALICLOUD_IDENTITY)
 
 	mgr := &ClientMgr{
@@ -97,8 +96,11 @@ func NewClientMgr(key, secret string) (*ClientMgr, error) {
 		instance: &InstanceClient{
 			c: ecsclient,
 		},
+		eip: &EIPClient{
+			c: &realECSEIPClient{ecsclient},
+		},
 		loadbalancer: &LoadBalancerClient{
-			c: slbclient,
+			c: &realSLBClient{slbclient},
 		},
 		routes: &RoutesClient{
 			client:  ecsclient,
@@ -149,6 +151,10 @@ func (c *ClientMgr) MetaData() IMetaData {
 	return c.meta
 }
 
+func (c *ClientMgr) EIP() *EIPClient {
+	return c.eip
+}
+
 type IMetaData interface {
 	HostName()(string, error)
 	ImageID() (string, error)
@@ -164,149 +170,29 @@ type IMetaData interface {
 	VpcID() (string, error)
 	VswitchCIDRBlock() (string, error)
 	Zone() (string, error)
+	EIPv4() (string, error)
 	NTPConfigServers() ([]string, error)
 	RoleName() (string, error)
 	RamRoleToken(role string) (metadata.RoleAuth, error)
 	VswitchID() (string, error)
 }
 
-func NewMetaData() IMetaData{
-	if cfg.Global.Region != "" &&
-		cfg.Global.VpcID != "" &&
-		cfg.Global.VswitchID != "" &&
-		cfg.Global.ZoneID != "" {
-		glog.V(2).Infof("use mocked metadata server.")
-		return &fakeMetaData{base: metadata.NewMetaData(nil)}
-	}
-	return metadata.NewMetaData(nil)
-}
-
-type fakeMetaData struct {
-	base 	IMetaData
-}
-
-func (m *fakeMetaData) HostName() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) ImageID() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) InstanceID() (string, error) {
-
-	return "fakedInstanceid",nil
-}
-
-func (m *fakeMetaData) Mac() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) NetworkType() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) OwnerAccountID() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) PrivateIPv4() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) Region() (string, error) {
-	if cfg.Global.Region != "" {
-		return cfg.Global.Region, nil
-	}
-	return m.base.Region()
-}
-
-func (m *fakeMetaData) SerialNumber() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) SourceAddress() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-
-}
-
-func (m *fakeMetaData) VpcCIDRBlock() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) VpcID() (string, error) {
-
-	return cfg.Global.VpcID,nil
-}
-
-func (m *fakeMetaData) VswitchCIDRBlock() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-// zone1:vswitchid1,zone2:vswitch2
-func (m *fakeMetaData) VswitchID() (string, error) {
-
-	zlist := strings.Split(cfg.Global.VswitchID,",")
-	if len(zlist) == 1 {
-		glog.Infof("simple vswitchid mode, %s",cfg.Global.VswitchID)
-		return cfg.Global.VswitchID,nil
+// NewMetaData builds the IMetaData implementation selected by
+// cfg.Global.MetadataProvider, defaulting to the real ECS metadata server.
+// See RegisterMetaDataProvider for how providers other than "ecs" are
+// plugged in.
+func NewMetaData() IMetaData {
+	name := cfg.Global.MetadataProvider
+	if name == "" {
+		name = metadataProviderECS
 	}
-	zone, err := m.Zone()
-	if err != nil {
-		return "",fmt.Errorf("retrieve vswitchid error for %s",err.Error())
+	factory, ok := metadataProviders[name]
+	if !ok {
+		glog.Errorf("alicloud: unknown metadata provider %q, falling back to %q", name, metadataProviderECS)
+		name = metadataProviderECS
+		factory = metadataProviders[name]
 	}
-	for _, zone := range zlist {
-		vs := strings.Split(zone,":")
-		if len(vs) != 2 {
-			return "", fmt.Errorf("cloud-config vswitch format error: %s",cfg.Global.VswitchID)
-		}
-		if vs[0] == zone {
-			return vs[1], nil
-		}
-	}
-	glog.Infof("zone[%s] match failed, fallback with simple vswitch id mode, [%s]",zone,cfg.Global.VswitchID)
-	return cfg.Global.VswitchID, nil
-}
-
-func (m *fakeMetaData) EIPv4() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) DNSNameServers() ([]string, error) {
-
-	return []string{""},fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) NTPConfigServers() ([]string, error) {
-
-	return []string{""},fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) Zone() (string, error) {
-	if cfg.Global.ZoneID != "" {
-		return cfg.Global.ZoneID, nil
-	}
-	return m.base.Zone()
-}
-
-func (m *fakeMetaData) RoleName() (string, error) {
-
-	return "",fmt.Errorf("unimplemented")
-}
-
-func (m *fakeMetaData) RamRoleToken(role string) (metadata.RoleAuth, error) {
-
-	return metadata.RoleAuth{},fmt.Errorf("unimplemented")
+	glog.V(2).Infof("alicloud: using %q metadata provider", name)
+	return factory()
 }
 
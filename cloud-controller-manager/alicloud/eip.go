@@ -0,0 +1,238 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/denverdino/aliyungo/ecs"
+	"k8s.io/api/core/v1"
+)
+
+// ServiceAnnotationLoadBalancerEIPId binds a Service to an EIP that was
+// allocated out of band; the controller associates it with the Service's
+// (typically intranet) SLB but never releases it.
+const ServiceAnnotationLoadBalancerEIPId = "service.beta.kubernetes.io/alicloud-loadbalancer-eip-id"
+
+// ServiceAnnotationLoadBalancerAllocateEIP asks the controller to allocate
+// and own an EIP for the Service's SLB, the way the OpenStack provider
+// associates a floating IP with an internal LB.
+const ServiceAnnotationLoadBalancerAllocateEIP = "service.beta.kubernetes.io/alicloud-loadbalancer-allocate-eip"
+
+// eipOwnerTagKey/eipOwnerTagValue are tagged onto every EIP this controller
+// allocates, so EnsureLoadBalancerDeleted can tell a controller-owned EIP
+// apart from one the user brought via ServiceAnnotationLoadBalancerEIPId and
+// release only the former. A tag survives edits to user-facing fields like
+// Description, unlike the marker string this used to be stamped into.
+const (
+	eipOwnerTagKey   = "kubernetes.io/alicloud-controller-manager"
+	eipOwnerTagValue = "owned"
+)
+
+// eipOwnerTag builds the tag payload AddTags expects for stamping an
+// allocated EIP as controller-owned.
+func eipOwnerTag() string {
+	tags, _ := json.Marshal([]ecs.TagItemType{{TagKey: eipOwnerTagKey, TagValue: eipOwnerTagValue}})
+	return string(tags)
+}
+
+// ClientECSEIPSDK is the subset of the aliyungo ecs.Client surface EIPClient
+// depends on. It exists so tests can substitute a mock instead of talking to
+// the real aliyun API, mirroring ClientSLBSDK.
+type ClientECSEIPSDK interface {
+	AllocateEipAddress(ctx context.Context, args *ecs.AllocateEipAddressArgs) (response *ecs.AllocateEipAddressResponse, err error)
+	AssociateEipAddress(ctx context.Context, allocationId, instanceId string) (err error)
+	UnassociateEipAddress(ctx context.Context, allocationId, instanceId string) (err error)
+	ReleaseEipAddress(ctx context.Context, allocationId string) (err error)
+	DescribeEipAddresses(ctx context.Context, args *ecs.DescribeEipAddressesArgs) (eips []ecs.EipAddressSetType, err error)
+	AddTags(ctx context.Context, args *ecs.AddTagsArgs) (err error)
+	DescribeTags(ctx context.Context, args *ecs.DescribeTagsArgs) (tags []ecs.TagItemType, err error)
+}
+
+// EIPClient wraps the subset of the aliyun ECS EIP API the controller needs
+// to associate an Elastic IP with an otherwise-intranet SLB.
+type EIPClient struct {
+	c ClientECSEIPSDK
+}
+
+// realECSEIPClient adapts the real aliyungo ecs.Client, whose EIP methods
+// predate context support, to ClientECSEIPSDK.
+type realECSEIPClient struct {
+	*ecs.Client
+}
+
+func (r *realECSEIPClient) AllocateEipAddress(ctx context.Context, args *ecs.AllocateEipAddressArgs) (*ecs.AllocateEipAddressResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.AllocateEipAddress(args)
+}
+
+func (r *realECSEIPClient) AssociateEipAddress(ctx context.Context, allocationId, instanceId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.AssociateEipAddress(allocationId, instanceId)
+}
+
+func (r *realECSEIPClient) UnassociateEipAddress(ctx context.Context, allocationId, instanceId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.UnassociateEipAddress(allocationId, instanceId)
+}
+
+func (r *realECSEIPClient) ReleaseEipAddress(ctx context.Context, allocationId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.ReleaseEipAddress(allocationId)
+}
+
+func (r *realECSEIPClient) DescribeEipAddresses(ctx context.Context, args *ecs.DescribeEipAddressesArgs) ([]ecs.EipAddressSetType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	eips, _, err := r.Client.DescribeEipAddresses(args)
+	return eips, err
+}
+
+func (r *realECSEIPClient) AddTags(ctx context.Context, args *ecs.AddTagsArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.AddTags(args)
+}
+
+func (r *realECSEIPClient) DescribeTags(ctx context.Context, args *ecs.DescribeTagsArgs) ([]ecs.TagItemType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tags, _, err := r.Client.DescribeTags(args)
+	return tags, err
+}
+
+// ensureEIP makes sure the SLB backing service has the EIP the Service asks
+// for associated with it, allocating a new one if the Service opted in to
+// ServiceAnnotationLoadBalancerAllocateEIP without pinning a specific id. It
+// returns the address to report on the Service's LoadBalancerIngress.
+func (c *Cloud) ensureEIP(ctx context.Context, service *v1.Service, loadBalancerId string) (string, error) {
+	eipClient := c.clientMgr.EIP()
+
+	if id := getAnnotation(service, ServiceAnnotationLoadBalancerEIPId, ""); id != "" {
+		return c.associateEIP(ctx, eipClient, id, loadBalancerId)
+	}
+
+	if getAnnotation(service, ServiceAnnotationLoadBalancerAllocateEIP, "") != "true" {
+		return "", nil
+	}
+
+	existing, err := eipClient.c.DescribeEipAddresses(ctx, &ecs.DescribeEipAddressesArgs{
+		RegionId:           region(),
+		AssociatedInstanceId: loadBalancerId,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 {
+		return existing[0].IpAddress, nil
+	}
+
+	resp, err := eipClient.c.AllocateEipAddress(ctx, &ecs.AllocateEipAddressArgs{
+		RegionId: region(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := eipClient.c.AddTags(ctx, &ecs.AddTagsArgs{
+		RegionId:     region(),
+		ResourceType: ecs.TagResourceEip,
+		ResourceId:   resp.AllocationId,
+		Tags:         eipOwnerTag(),
+	}); err != nil {
+		return "", err
+	}
+	return c.associateEIP(ctx, eipClient, resp.AllocationId, loadBalancerId)
+}
+
+func (c *Cloud) associateEIP(ctx context.Context, eipClient *EIPClient, allocationId, loadBalancerId string) (string, error) {
+	eips, err := eipClient.c.DescribeEipAddresses(ctx, &ecs.DescribeEipAddressesArgs{AllocationId: allocationId})
+	if err != nil {
+		return "", err
+	}
+	if len(eips) == 0 {
+		return "", fmt.Errorf("alicloud: eip %s not found", allocationId)
+	}
+	eip := eips[0]
+	if eip.InstanceId != loadBalancerId {
+		if err := eipClient.c.AssociateEipAddress(ctx, allocationId, loadBalancerId); err != nil {
+			return "", err
+		}
+	}
+	return eip.IpAddress, nil
+}
+
+// releaseControllerOwnedEIP releases the EIP bound to loadBalancerId, but
+// only if this controller is the one that allocated it - an EIP pinned via
+// ServiceAnnotationLoadBalancerEIPId outlives the Service.
+func (c *Cloud) releaseControllerOwnedEIP(ctx context.Context, loadBalancerId string) error {
+	eipClient := c.clientMgr.EIP()
+	eips, err := eipClient.c.DescribeEipAddresses(ctx, &ecs.DescribeEipAddressesArgs{
+		RegionId:           region(),
+		AssociatedInstanceId: loadBalancerId,
+	})
+	if err != nil {
+		return err
+	}
+	for _, eip := range eips {
+		owned, err := isControllerOwnedEIP(ctx, eipClient, eip.AllocationId)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			continue
+		}
+		if err := eipClient.c.UnassociateEipAddress(ctx, eip.AllocationId, loadBalancerId); err != nil {
+			return err
+		}
+		if err := eipClient.c.ReleaseEipAddress(ctx, eip.AllocationId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isControllerOwnedEIP reports whether allocationId carries the tag stamped
+// on every EIP this controller itself allocated.
+func isControllerOwnedEIP(ctx context.Context, eipClient *EIPClient, allocationId string) (bool, error) {
+	tags, err := eipClient.c.DescribeTags(ctx, &ecs.DescribeTagsArgs{
+		RegionId:     region(),
+		ResourceType: ecs.TagResourceEip,
+		ResourceId:   allocationId,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tags {
+		if tag.TagKey == eipOwnerTagKey && tag.TagValue == eipOwnerTagValue {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/denverdino/aliyungo/common"
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// gcSweepInterval is how often the orphan-SLB sweep runs when
+// CloudConfig.Global.EnableLoadBalancerGC is set.
+const gcSweepInterval = 10 * time.Minute
+
+// clusterIdTagKey is the SLB tag key this controller writes at create time
+// and checks at sweep time, so the GC sweep only ever touches SLBs it
+// itself created for this cluster.
+const clusterIdTagKey = "kubernetes.io/cluster-id"
+
+var (
+	slbOrphansFound = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "alicloud_slb_orphans_found",
+		Help: "Number of SLB instances found tagged for this cluster with no matching Service.",
+	})
+	slbOrphansDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "alicloud_slb_orphans_deleted",
+		Help: "Number of orphaned SLB instances deleted by the cross-region GC sweep.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(slbOrphansFound, slbOrphansDeleted)
+}
+
+// clusterIdTag builds the tag payload DescribeLoadBalancers/AddTags expect
+// for stamping an SLB with the owning cluster id.
+func clusterIdTag(clusterID string) string {
+	tags, _ := json.Marshal([]slb.TagItemType{{TagKey: clusterIdTagKey, TagValue: clusterID}})
+	return string(tags)
+}
+
+// startLoadBalancerGC launches the periodic cross-region orphan sweep, if
+// the operator opted in via CloudConfig.Global.EnableLoadBalancerGC. It is a
+// no-op otherwise, and also when CloudConfig.Global.Regions is empty.
+func (c *Cloud) startLoadBalancerGC(stop <-chan struct{}) {
+	if !cfg.Global.EnableLoadBalancerGC || len(cfg.Global.Regions) == 0 {
+		return
+	}
+	limiter := flowcontrol.NewTokenBucketRateLimiter(0.2, 1)
+	go wait.Until(func() {
+		c.sweepOrphanLoadBalancers(context.Background(), limiter)
+	}, gcSweepInterval, stop)
+}
+
+// sweepOrphanLoadBalancers lists every SLB tagged for this cluster across
+// every configured region, cross-references them with the live Service set,
+// and deletes the ones whose owning Service no longer exists.
+func (c *Cloud) sweepOrphanLoadBalancers(ctx context.Context, limiter flowcontrol.RateLimiter) {
+	if c.serviceLister == nil {
+		glog.Warning("alicloud: loadbalancer gc sweep skipped, service informer not wired up")
+		return
+	}
+
+	live := sets.NewString()
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("alicloud: loadbalancer gc sweep failed to list services: %v", err)
+		return
+	}
+	for _, service := range services {
+		if id := resolveLoadBalancerId(service); id != "" {
+			live.Insert(id)
+		}
+	}
+
+	lbclient := c.clientMgr.LoadBalancers()
+	for _, region := range cfg.Global.Regions {
+		lbs, err := lbclient.c.DescribeLoadBalancers(ctx, &slb.DescribeLoadBalancersArgs{RegionId: common.Region(region)})
+		if err != nil {
+			glog.Errorf("alicloud: loadbalancer gc sweep failed to list loadbalancers in region %s: %v", region, err)
+			continue
+		}
+		for _, lb := range lbs {
+			tags, err := lbclient.c.DescribeTags(ctx, &slb.DescribeTagsArgs{RegionId: common.Region(region), LoadBalancerId: lb.LoadBalancerId})
+			if err != nil {
+				glog.Errorf("alicloud: loadbalancer gc sweep failed to describe tags for %s: %v", lb.LoadBalancerId, err)
+				continue
+			}
+			if !ownedByCluster(tags, cfg.Global.KubernetesClusterTag) || live.Has(lb.LoadBalancerId) {
+				continue
+			}
+
+			slbOrphansFound.Inc()
+			glog.V(2).Infof("alicloud: loadbalancer gc sweep found orphan %s in region %s", lb.LoadBalancerId, region)
+			if !limiter.TryAccept() {
+				continue
+			}
+			if err := lbclient.c.DeleteLoadBalancer(ctx, lb.LoadBalancerId); err != nil {
+				glog.Errorf("alicloud: loadbalancer gc sweep failed to delete orphan %s: %v", lb.LoadBalancerId, err)
+				continue
+			}
+			slbOrphansDeleted.Inc()
+		}
+	}
+}
+
+func ownedByCluster(tags []slb.TagItemType, clusterID string) bool {
+	if clusterID == "" {
+		return false
+	}
+	for _, tag := range tags {
+		if tag.TagKey == clusterIdTagKey && tag.TagValue == clusterID {
+			return true
+		}
+	}
+	return false
+}
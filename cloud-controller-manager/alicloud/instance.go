@@ -0,0 +1,39 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+
+	"github.com/denverdino/aliyungo/ecs"
+)
+
+// InstanceClient wraps the raw ECS client; ENI-mode backend resolution uses
+// it to look up the network interfaces that carry pod IPs.
+type InstanceClient struct {
+	c *ecs.Client
+}
+
+// DescribeNetworkInterfaces lists the ENIs matching args, honoring ctx
+// cancellation the way LoadBalancerClient and EIPClient already do for
+// their ECS/SLB calls.
+func (i *InstanceClient) DescribeNetworkInterfaces(ctx context.Context, args *ecs.DescribeNetworkInterfacesArgs) ([]ecs.NetworkInterfaceType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return i.c.DescribeNetworkInterfaces(args)
+}
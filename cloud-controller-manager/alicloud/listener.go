@@ -0,0 +1,285 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+)
+
+// Service annotations controlling the SLB scheduling algorithm and
+// per-listener health-check tuning. They all share the
+// service.beta.kubernetes.io/alicloud-loadbalancer- prefix used by the
+// rest of the annotations this controller understands.
+const (
+	ServiceAnnotationLoadBalancerScheduler = "service.beta.kubernetes.io/alicloud-loadbalancer-scheduler"
+
+	ServiceAnnotationLoadBalancerHealthCheckType     = "service.beta.kubernetes.io/alicloud-loadbalancer-health-check-type"
+	ServiceAnnotationLoadBalancerHealthCheckURI      = "service.beta.kubernetes.io/alicloud-loadbalancer-health-check-uri"
+	ServiceAnnotationLoadBalancerHealthCheckDomain   = "service.beta.kubernetes.io/alicloud-loadbalancer-health-check-domain"
+	ServiceAnnotationLoadBalancerHealthCheckInterval = "service.beta.kubernetes.io/alicloud-loadbalancer-health-check-interval"
+	ServiceAnnotationLoadBalancerHealthCheckTimeout  = "service.beta.kubernetes.io/alicloud-loadbalancer-health-check-timeout"
+	ServiceAnnotationLoadBalancerHealthyThreshold    = "service.beta.kubernetes.io/alicloud-loadbalancer-healthy-threshold"
+	ServiceAnnotationLoadBalancerUnhealthyThreshold  = "service.beta.kubernetes.io/alicloud-loadbalancer-unhealthy-threshold"
+)
+
+// Scheduler algorithms accepted by ServiceAnnotationLoadBalancerScheduler.
+const (
+	SchedulerWRR = "wrr"
+	SchedulerWLC = "wlc"
+	SchedulerRR  = "rr"
+	SchedulerSCH = "sch"
+)
+
+// listenerHealthCheck is the health-check and scheduler tuning parsed out of
+// a Service's annotations, applied uniformly across its listeners.
+type listenerHealthCheck struct {
+	Scheduler          string
+	HealthCheckType     string
+	HealthCheckURI      string
+	HealthCheckDomain   string
+	HealthCheckInterval int
+	HealthCheckTimeout  int
+	HealthyThreshold    int
+	UnhealthyThreshold  int
+}
+
+// desiredListenerHealthCheck reads the scheduler/health-check annotations
+// off a Service, falling back to the SLB defaults for anything unset.
+func desiredListenerHealthCheck(service *v1.Service) listenerHealthCheck {
+	hc := listenerHealthCheck{
+		Scheduler:           getAnnotation(service, ServiceAnnotationLoadBalancerScheduler, SchedulerWRR),
+		HealthCheckType:     getAnnotation(service, ServiceAnnotationLoadBalancerHealthCheckType, "tcp"),
+		HealthCheckURI:      getAnnotation(service, ServiceAnnotationLoadBalancerHealthCheckURI, "/"),
+		HealthCheckDomain:   getAnnotation(service, ServiceAnnotationLoadBalancerHealthCheckDomain, ""),
+		HealthCheckInterval: getAnnotationInt(service, ServiceAnnotationLoadBalancerHealthCheckInterval, 2),
+		HealthCheckTimeout:  getAnnotationInt(service, ServiceAnnotationLoadBalancerHealthCheckTimeout, 5),
+		HealthyThreshold:    getAnnotationInt(service, ServiceAnnotationLoadBalancerHealthyThreshold, 3),
+		UnhealthyThreshold:  getAnnotationInt(service, ServiceAnnotationLoadBalancerUnhealthyThreshold, 3),
+	}
+	return hc
+}
+
+func getAnnotation(service *v1.Service, key, def string) string {
+	if v, ok := service.Annotations[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func getAnnotationInt(service *v1.Service, key string, def int) int {
+	v, ok := service.Annotations[key]
+	if !ok || v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		glog.Warningf("alicloud: invalid integer annotation %s=%q on service %s/%s, using default %d", key, v, service.Namespace, service.Name, def)
+		return def
+	}
+	return i
+}
+
+// existingListenerProtocols maps each listener port already provisioned on
+// the SLB to the protocol it was created with, as reported by
+// DescribeLoadBalancers/DescribeLoadBalancerAttribute's
+// ListenerPortsAndProtocol.
+func existingListenerProtocols(lb *slb.LoadBalancerType) map[int]string {
+	existing := make(map[int]string, len(lb.ListenerPortsAndProtocol.ListenerPortAndProtocol))
+	for _, entry := range lb.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		port, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		existing[port] = parts[1]
+	}
+	return existing
+}
+
+// reconcileListeners ensures every port in the Service's spec has a matching
+// SLB listener, creating whichever ones are missing - without this, an SLB
+// comes up with no listeners and no traffic can ever reach its backends.
+func reconcileListeners(ctx context.Context, client ClientSLBSDK, loadBalancerId string, lb *slb.LoadBalancerType, service *v1.Service) error {
+	hc := desiredListenerHealthCheck(service)
+	existing := existingListenerProtocols(lb)
+	for _, port := range service.Spec.Ports {
+		protocol, ok := existing[int(port.Port)]
+		if !ok {
+			if err := createListener(ctx, client, loadBalancerId, port, hc); err != nil {
+				return err
+			}
+			if err := client.StartLoadBalancerListener(ctx, loadBalancerId, int(port.Port)); err != nil {
+				return err
+			}
+			glog.V(2).Infof("alicloud: created %s listener %s:%d for service %s/%s", port.Protocol, loadBalancerId, port.Port, service.Namespace, service.Name)
+			continue
+		}
+		if err := reconcileExistingListener(ctx, client, loadBalancerId, int(port.Port), protocol, hc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileExistingListener pushes the Service's scheduler/health-check
+// annotations onto a listener that already exists, dispatching on the
+// protocol it was provisioned with.
+func reconcileExistingListener(ctx context.Context, client ClientSLBSDK, loadBalancerId string, port int, protocol string, hc listenerHealthCheck) error {
+	switch protocol {
+	case "https":
+		return applyHTTPListenerHealthCheck(ctx, client, loadBalancerId, port, true, hc)
+	case "http":
+		return applyHTTPListenerHealthCheck(ctx, client, loadBalancerId, port, false, hc)
+	case "udp":
+		return applyListenerHealthCheck(ctx, client, loadBalancerId, port, v1.ProtocolUDP, hc)
+	default:
+		return reconcileTCPListener(ctx, client, loadBalancerId, port, hc)
+	}
+}
+
+// createListener creates the SLB listener for a single Service port, using
+// the Service's scheduler/health-check annotations for its initial
+// attributes.
+func createListener(ctx context.Context, client ClientSLBSDK, loadBalancerId string, port v1.ServicePort, hc listenerHealthCheck) error {
+	if port.Protocol == v1.ProtocolUDP {
+		return client.CreateLoadBalancerUDPListener(ctx, &slb.CreateLoadBalancerUDPListenerArgs{
+			LoadBalancerId:      loadBalancerId,
+			ListenerPort:        int(port.Port),
+			BackendServerPort:   int(port.NodePort),
+			Bandwidth:           -1,
+			HealthCheckInterval: hc.HealthCheckInterval,
+			HealthCheckTimeout:  hc.HealthCheckTimeout,
+			HealthyThreshold:    hc.HealthyThreshold,
+			UnhealthyThreshold:  hc.UnhealthyThreshold,
+		})
+	}
+	return client.CreateLoadBalancerTCPListener(ctx, &slb.CreateLoadBalancerTCPListenerArgs{
+		LoadBalancerId:      loadBalancerId,
+		ListenerPort:        int(port.Port),
+		BackendServerPort:   int(port.NodePort),
+		Bandwidth:           -1,
+		Scheduler:           hc.Scheduler,
+		HealthCheckType:     hc.HealthCheckType,
+		HealthCheckDomain:   hc.HealthCheckDomain,
+		HealthCheckURI:      hc.HealthCheckURI,
+		HealthCheckInterval: hc.HealthCheckInterval,
+		HealthCheckTimeout:  hc.HealthCheckTimeout,
+		HealthyThreshold:    hc.HealthyThreshold,
+		UnhealthyThreshold:  hc.UnhealthyThreshold,
+	})
+}
+
+// listenerNeedsUpdate reports whether a TCP listener's live scheduler or
+// health-check attributes have drifted from the Service's annotations.
+func listenerNeedsUpdate(attr *slb.DescribeLoadBalancerTCPListenerAttributeResponse, hc listenerHealthCheck) bool {
+	return attr.Scheduler != hc.Scheduler ||
+		attr.HealthCheckType != hc.HealthCheckType ||
+		attr.HealthCheckURI != hc.HealthCheckURI ||
+		attr.HealthCheckDomain != hc.HealthCheckDomain ||
+		attr.HealthCheckInterval != hc.HealthCheckInterval ||
+		attr.HealthCheckTimeout != hc.HealthCheckTimeout ||
+		attr.HealthyThreshold != hc.HealthyThreshold ||
+		attr.UnhealthyThreshold != hc.UnhealthyThreshold
+}
+
+// reconcileTCPListener updates an existing TCP listener's scheduler and
+// health-check attributes in place when they drift from the Service's
+// annotations, instead of deleting and recreating the listener - which
+// would otherwise drop traffic while the health checks warm back up.
+func reconcileTCPListener(ctx context.Context, client ClientSLBSDK, loadBalancerId string, port int, hc listenerHealthCheck) error {
+	attr, err := client.DescribeLoadBalancerTCPListenerAttribute(ctx, loadBalancerId, port)
+	if err != nil {
+		return err
+	}
+	if !listenerNeedsUpdate(attr, hc) {
+		return nil
+	}
+	glog.V(2).Infof("alicloud: listener %s:%d attributes drifted, updating in place", loadBalancerId, port)
+	return applyListenerHealthCheck(ctx, client, loadBalancerId, port, v1.ProtocolTCP, hc)
+}
+
+// applyListenerHealthCheck pushes the desired scheduler/health-check tuning
+// onto an existing listener, dispatching to the Set*ListenerAttribute call
+// appropriate for the listener's protocol. UDP listeners get only the
+// interval, timeout and threshold fields; the scheduler, check type, URI and
+// domain are not sent for them.
+func applyListenerHealthCheck(ctx context.Context, client ClientSLBSDK, loadBalancerId string, port int, protocol v1.Protocol, hc listenerHealthCheck) error {
+	switch protocol {
+	case v1.ProtocolUDP:
+		return client.SetLoadBalancerUDPListenerAttribute(ctx, &slb.SetLoadBalancerUDPListenerAttributeArgs{
+			LoadBalancerId:      loadBalancerId,
+			ListenerPort:        port,
+			HealthCheckInterval: hc.HealthCheckInterval,
+			HealthCheckTimeout:  hc.HealthCheckTimeout,
+			HealthyThreshold:    hc.HealthyThreshold,
+			UnhealthyThreshold:  hc.UnhealthyThreshold,
+		})
+	default:
+		return client.SetLoadBalancerTCPListenerAttribute(ctx, &slb.SetLoadBalancerTCPListenerAttributeArgs{
+			LoadBalancerId:      loadBalancerId,
+			ListenerPort:        port,
+			Scheduler:           hc.Scheduler,
+			HealthCheckType:     hc.HealthCheckType,
+			HealthCheckDomain:   hc.HealthCheckDomain,
+			HealthCheckURI:      hc.HealthCheckURI,
+			HealthCheckInterval: hc.HealthCheckInterval,
+			HealthCheckTimeout:  hc.HealthCheckTimeout,
+			HealthyThreshold:    hc.HealthyThreshold,
+			UnhealthyThreshold:  hc.UnhealthyThreshold,
+		})
+	}
+}
+
+// applyHTTPListenerHealthCheck is the HTTP/HTTPS counterpart of
+// applyListenerHealthCheck; HTTP(S) listeners take their scheduler and
+// health-check fields on distinct Set*ListenerAttribute args types, and the
+// health-check type is not sent for them.
+func applyHTTPListenerHealthCheck(ctx context.Context, client ClientSLBSDK, loadBalancerId string, port int, https bool, hc listenerHealthCheck) error {
+	if https {
+		return client.SetLoadBalancerHTTPSListenerAttribute(ctx, &slb.SetLoadBalancerHTTPSListenerAttributeArgs{
+			LoadBalancerId:      loadBalancerId,
+			ListenerPort:        port,
+			Scheduler:           hc.Scheduler,
+			HealthCheckURI:      hc.HealthCheckURI,
+			HealthCheckDomain:   hc.HealthCheckDomain,
+			HealthCheckInterval: hc.HealthCheckInterval,
+			HealthCheckTimeout:  hc.HealthCheckTimeout,
+			HealthyThreshold:    hc.HealthyThreshold,
+			UnhealthyThreshold:  hc.UnhealthyThreshold,
+		})
+	}
+	return client.SetLoadBalancerHTTPListenerAttribute(ctx, &slb.SetLoadBalancerHTTPListenerAttributeArgs{
+		LoadBalancerId:      loadBalancerId,
+		ListenerPort:        port,
+		Scheduler:           hc.Scheduler,
+		HealthCheckURI:      hc.HealthCheckURI,
+		HealthCheckDomain:   hc.HealthCheckDomain,
+		HealthCheckInterval: hc.HealthCheckInterval,
+		HealthCheckTimeout:  hc.HealthCheckTimeout,
+		HealthyThreshold:    hc.HealthyThreshold,
+		UnhealthyThreshold:  hc.UnhealthyThreshold,
+	})
+}
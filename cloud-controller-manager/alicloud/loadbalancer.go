@@ -0,0 +1,438 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/denverdino/aliyungo/slb"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// ServiceAnnotationLoadBalancerId lets a user bind a Service to an SLB
+// instance that was created out of band, instead of having the controller
+// create and own one for the Service's lifetime.
+const ServiceAnnotationLoadBalancerId = "service.beta.kubernetes.io/alicloud-loadbalancer-id"
+
+// ClientSLBSDK is the subset of the aliyungo slb.Client surface the
+// LoadBalancerClient depends on. It exists so tests can substitute
+// mockClientSLB instead of talking to the real aliyun API. Every method
+// takes a context.Context as its first argument so slow SLB calls can be
+// cancelled when kube-controller-manager shuts down or a reconcile is
+// superseded; the underlying aliyungo SDK does not understand contexts
+// itself, so realSLBClient only uses it to bail out before issuing the
+// call.
+type ClientSLBSDK interface {
+	DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) (loadBalancers []slb.LoadBalancerType, err error)
+	CreateLoadBalancer(ctx context.Context, args *slb.CreateLoadBalancerArgs) (response *slb.CreateLoadBalancerResponse, err error)
+	DeleteLoadBalancer(ctx context.Context, loadBalancerId string) (err error)
+	ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) (err error)
+	DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (loadBalancer *slb.LoadBalancerType, err error)
+	RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []string) (result []slb.BackendServerType, err error)
+	AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) (result []slb.BackendServerType, err error)
+
+	StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error)
+	StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error)
+	CreateLoadBalancerTCPListener(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) (err error)
+	CreateLoadBalancerUDPListener(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) (err error)
+	DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) (err error)
+	CreateLoadBalancerHTTPSListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) (err error)
+	CreateLoadBalancerHTTPListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) (err error)
+	DescribeLoadBalancerHTTPSListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, err error)
+	DescribeLoadBalancerTCPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerTCPListenerAttributeResponse, err error)
+	DescribeLoadBalancerUDPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerUDPListenerAttributeResponse, err error)
+	DescribeLoadBalancerHTTPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (response *slb.DescribeLoadBalancerHTTPListenerAttributeResponse, err error)
+
+	SetLoadBalancerTCPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) (err error)
+	SetLoadBalancerUDPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) (err error)
+	SetLoadBalancerHTTPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) (err error)
+	SetLoadBalancerHTTPSListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) (err error)
+
+	AddTags(ctx context.Context, args *slb.AddTagsArgs) (err error)
+	DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) (tags []slb.TagItemType, err error)
+}
+
+// LoadBalancerClient reconciles Kubernetes Service objects of type
+// LoadBalancer against Alibaba Cloud SLB instances.
+type LoadBalancerClient struct {
+	c ClientSLBSDK
+}
+
+// realSLBClient adapts the real aliyungo slb.Client, whose methods predate
+// context support, to ClientSLBSDK.
+type realSLBClient struct {
+	*slb.Client
+}
+
+func (r *realSLBClient) DescribeLoadBalancers(ctx context.Context, args *slb.DescribeLoadBalancersArgs) ([]slb.LoadBalancerType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancers(args)
+}
+
+func (r *realSLBClient) CreateLoadBalancer(ctx context.Context, args *slb.CreateLoadBalancerArgs) (*slb.CreateLoadBalancerResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.CreateLoadBalancer(args)
+}
+
+func (r *realSLBClient) DeleteLoadBalancer(ctx context.Context, loadBalancerId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.DeleteLoadBalancer(loadBalancerId)
+}
+
+func (r *realSLBClient) ModifyLoadBalancerInternetSpec(ctx context.Context, args *slb.ModifyLoadBalancerInternetSpecArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.ModifyLoadBalancerInternetSpec(args)
+}
+
+func (r *realSLBClient) DescribeLoadBalancerAttribute(ctx context.Context, loadBalancerId string) (*slb.LoadBalancerType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancerAttribute(loadBalancerId)
+}
+
+func (r *realSLBClient) RemoveBackendServers(ctx context.Context, loadBalancerId string, backendServers []string) ([]slb.BackendServerType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.RemoveBackendServers(loadBalancerId, backendServers)
+}
+
+func (r *realSLBClient) AddBackendServers(ctx context.Context, loadBalancerId string, backendServers []slb.BackendServerType) ([]slb.BackendServerType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.AddBackendServers(loadBalancerId, backendServers)
+}
+
+func (r *realSLBClient) StartLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.StartLoadBalancerListener(loadBalancerId, port)
+}
+
+func (r *realSLBClient) StopLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.StopLoadBalancerListener(loadBalancerId, port)
+}
+
+func (r *realSLBClient) CreateLoadBalancerTCPListener(ctx context.Context, args *slb.CreateLoadBalancerTCPListenerArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.CreateLoadBalancerTCPListener(args)
+}
+
+func (r *realSLBClient) CreateLoadBalancerUDPListener(ctx context.Context, args *slb.CreateLoadBalancerUDPListenerArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.CreateLoadBalancerUDPListener(args)
+}
+
+func (r *realSLBClient) DeleteLoadBalancerListener(ctx context.Context, loadBalancerId string, port int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.DeleteLoadBalancerListener(loadBalancerId, port)
+}
+
+func (r *realSLBClient) CreateLoadBalancerHTTPSListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPSListenerArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.CreateLoadBalancerHTTPSListener(args)
+}
+
+func (r *realSLBClient) CreateLoadBalancerHTTPListener(ctx context.Context, args *slb.CreateLoadBalancerHTTPListenerArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.CreateLoadBalancerHTTPListener(args)
+}
+
+func (r *realSLBClient) DescribeLoadBalancerHTTPSListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerHTTPSListenerAttributeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancerHTTPSListenerAttribute(loadBalancerId, port)
+}
+
+func (r *realSLBClient) DescribeLoadBalancerTCPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerTCPListenerAttributeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancerTCPListenerAttribute(loadBalancerId, port)
+}
+
+func (r *realSLBClient) DescribeLoadBalancerUDPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerUDPListenerAttributeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancerUDPListenerAttribute(loadBalancerId, port)
+}
+
+func (r *realSLBClient) DescribeLoadBalancerHTTPListenerAttribute(ctx context.Context, loadBalancerId string, port int) (*slb.DescribeLoadBalancerHTTPListenerAttributeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Client.DescribeLoadBalancerHTTPListenerAttribute(loadBalancerId, port)
+}
+
+func (r *realSLBClient) SetLoadBalancerTCPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerTCPListenerAttributeArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.SetLoadBalancerTCPListenerAttribute(args)
+}
+
+func (r *realSLBClient) SetLoadBalancerUDPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerUDPListenerAttributeArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.SetLoadBalancerUDPListenerAttribute(args)
+}
+
+func (r *realSLBClient) SetLoadBalancerHTTPListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPListenerAttributeArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.SetLoadBalancerHTTPListenerAttribute(args)
+}
+
+func (r *realSLBClient) SetLoadBalancerHTTPSListenerAttribute(ctx context.Context, args *slb.SetLoadBalancerHTTPSListenerAttributeArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.SetLoadBalancerHTTPSListenerAttribute(args)
+}
+
+func (r *realSLBClient) AddTags(ctx context.Context, args *slb.AddTagsArgs) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Client.AddTags(args)
+}
+
+func (r *realSLBClient) DescribeTags(ctx context.Context, args *slb.DescribeTagsArgs) ([]slb.TagItemType, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tags, _, err := r.Client.DescribeTags(args)
+	return tags, err
+}
+
+// loadBalancerDomain encodes the SLB id into the hostname reported on the
+// Service's ingress status, so a later reconcile can recover which SLB it
+// owns without relying solely on the (mutable) id annotation.
+func loadBalancerDomain(serviceName, id, region string) string {
+	return fmt.Sprintf("%s.%s.%s.alicloud-slb.com", id, serviceName, region)
+}
+
+// idFromLoadBalancerDomain extracts the SLB id previously encoded by
+// loadBalancerDomain out of an ingress hostname.
+func idFromLoadBalancerDomain(hostname string) string {
+	parts := strings.SplitN(hostname, ".", 2)
+	return parts[0]
+}
+
+// loadbalancerAttrib returns lb as is, standing in for the
+// DescribeLoadBalancerAttribute result of the SLB that lb describes; it
+// neither fetches nor fills in any attribute.
+func loadbalancerAttrib(lb *slb.LoadBalancerType) *slb.LoadBalancerType {
+	return lb
+}
+
+// resolveLoadBalancerId figures out which SLB a Service is bound to, without
+// talking to the API: explicit annotation first, falling back to the id
+// this controller previously encoded into the ingress hostname.
+func resolveLoadBalancerId(service *v1.Service) string {
+	if id, ok := service.Annotations[ServiceAnnotationLoadBalancerId]; ok && id != "" {
+		return id
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.Hostname != "" {
+			return idFromLoadBalancerDomain(ingress.Hostname)
+		}
+	}
+	return ""
+}
+
+// findLoadBalancer resolves the Service to its backing SLB instance, if one
+// exists: by explicit annotation, by the id previously recorded in the
+// ingress status, or by the deterministic name generated from the Service's
+// UID.
+func (client *LoadBalancerClient) findLoadBalancer(ctx context.Context, service *v1.Service) (bool, *slb.LoadBalancerType, error) {
+	args := &slb.DescribeLoadBalancersArgs{RegionId: region()}
+	if id := resolveLoadBalancerId(service); id != "" {
+		args.LoadBalancerId = id
+	} else {
+		args.LoadBalancerName = cloudprovider.GetLoadBalancerName(service)
+	}
+
+	lbs, err := client.c.DescribeLoadBalancers(ctx, args)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(lbs) == 0 {
+		return false, nil, nil
+	}
+
+	lb, err := client.c.DescribeLoadBalancerAttribute(ctx, lbs[0].LoadBalancerId)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, lb, nil
+}
+
+// GetLoadBalancer returns whether the Service has a backing SLB and, if so,
+// its current status.
+func (c *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	exist, lb, err := c.clientMgr.LoadBalancers().findLoadBalancer(ctx, service)
+	if err != nil || !exist {
+		return nil, exist, err
+	}
+	return &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{IP: lb.Address}},
+	}, true, nil
+}
+
+// GetLoadBalancerName returns the deterministic SLB name this controller
+// would create for the Service, absent an override annotation.
+func (c *Cloud) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return cloudprovider.GetLoadBalancerName(service)
+}
+
+// EnsureLoadBalancer creates the SLB backing the Service if it does not
+// already exist, and returns its current status.
+func (c *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	lbclient := c.clientMgr.LoadBalancers()
+	exist, lb, err := lbclient.findLoadBalancer(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	loadBalancerId := ""
+	address := ""
+	var lbAttrs *slb.LoadBalancerType
+	if !exist {
+		resp, err := lbclient.c.CreateLoadBalancer(ctx, &slb.CreateLoadBalancerArgs{
+			RegionId:         region(),
+			LoadBalancerName: cloudprovider.GetLoadBalancerName(service),
+		})
+		if err != nil {
+			return nil, err
+		}
+		glog.V(2).Infof("alicloud: created loadbalancer %s for service %s/%s", resp.LoadBalancerId, service.Namespace, service.Name)
+		if cfg.Global.KubernetesClusterTag != "" {
+			if err := lbclient.c.AddTags(ctx, &slb.AddTagsArgs{
+				RegionId:       region(),
+				LoadBalancerId: resp.LoadBalancerId,
+				Tags:           clusterIdTag(cfg.Global.KubernetesClusterTag),
+			}); err != nil {
+				glog.Errorf("alicloud: failed to tag loadbalancer %s with cluster id: %v", resp.LoadBalancerId, err)
+			}
+		}
+		loadBalancerId, address = resp.LoadBalancerId, resp.Address
+		lbAttrs = &slb.LoadBalancerType{LoadBalancerId: loadBalancerId}
+	} else {
+		loadBalancerId, address = lb.LoadBalancerId, lb.Address
+		lbAttrs = lb
+	}
+
+	if err := reconcileListeners(ctx, lbclient.c, loadBalancerId, lbAttrs, service); err != nil {
+		return nil, err
+	}
+
+	backends, err := c.loadBalancerBackends(ctx, service, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if err := reconcileBackends(ctx, lbclient.c, loadBalancerId, lbAttrs.BackendServers.BackendServer, backends); err != nil {
+		return nil, err
+	}
+
+	if eip, err := c.ensureEIP(ctx, service, loadBalancerId); err != nil {
+		return nil, err
+	} else if eip != "" {
+		address = eip
+	}
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{
+		IP:       address,
+		Hostname: loadBalancerDomain(service.Name, loadBalancerId, string(region())),
+	}}}, nil
+}
+
+// UpdateLoadBalancer reconciles the SLB's listener set and backend server
+// set against the Service's current spec and the current set of nodes.
+func (c *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	lbclient := c.clientMgr.LoadBalancers()
+	exist, lb, err := lbclient.findLoadBalancer(ctx, service)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("alicloud: loadbalancer for service %s/%s not found", service.Namespace, service.Name)
+	}
+
+	if err := reconcileListeners(ctx, lbclient.c, lb.LoadBalancerId, lb, service); err != nil {
+		return err
+	}
+
+	backends, err := c.loadBalancerBackends(ctx, service, nodes)
+	if err != nil {
+		return err
+	}
+
+	return reconcileBackends(ctx, lbclient.c, lb.LoadBalancerId, lb.BackendServers.BackendServer, backends)
+}
+
+// loadBalancerBackends computes the desired SLB backend server set for
+// service given the current set of nodes, dispatching on its backend mode.
+func (c *Cloud) loadBalancerBackends(ctx context.Context, service *v1.Service, nodes []*v1.Node) ([]slb.BackendServerType, error) {
+	if backendType(service) == BackendTypeENI {
+		return c.eniBackends(ctx, service)
+	}
+	return ecsBackends(nodes), nil
+}
+
+// EnsureLoadBalancerDeleted deletes the SLB backing the Service, if any.
+func (c *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	lbclient := c.clientMgr.LoadBalancers()
+	exist, lb, err := lbclient.findLoadBalancer(ctx, service)
+	if err != nil || !exist {
+		return err
+	}
+	if err := c.releaseControllerOwnedEIP(ctx, lb.LoadBalancerId); err != nil {
+		return err
+	}
+	return lbclient.c.DeleteLoadBalancer(ctx, lb.LoadBalancerId)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/denverdino/aliyungo/metadata"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+const (
+	metadataProviderECS  = "ecs"
+	metadataProviderFile = "file"
+	metadataProviderEnv  = "env"
+)
+
+// metadataFileEnvVar names the environment variable that points the "file"
+// metadata provider at its document.
+const metadataFileEnvVar = "ALICLOUD_METADATA_FILE"
+
+// metadataProviders holds every IMetaData implementation NewMetaData can
+// build, keyed by the name an operator sets in cfg.Global.MetadataProvider.
+var metadataProviders = map[string]func() IMetaData{}
+
+// RegisterMetaDataProvider makes an IMetaData implementation selectable via
+// cfg.Global.MetadataProvider. It is meant to be called from an init()
+// function; registering the same name twice overwrites the earlier entry.
+func RegisterMetaDataProvider(name string, factory func() IMetaData) {
+	metadataProviders[name] = factory
+}
+
+func init() {
+	RegisterMetaDataProvider(metadataProviderECS, func() IMetaData {
+		return metadata.NewMetaData(nil)
+	})
+	RegisterMetaDataProvider(metadataProviderFile, newFileMetaData)
+	RegisterMetaDataProvider(metadataProviderEnv, func() IMetaData {
+		return &envMetaData{}
+	})
+}
+
+// metadataDocument is the shape of the file the "file" metadata provider
+// reads from ALICLOUD_METADATA_FILE, meant for operators running this
+// controller outside Alibaba Cloud (CI, on-prem hybrid, ...).
+type metadataDocument struct {
+	HostName     string            `json:"hostName" yaml:"hostName"`
+	ImageID      string            `json:"imageId" yaml:"imageId"`
+	InstanceID   string            `json:"instanceId" yaml:"instanceId"`
+	Mac          string            `json:"mac" yaml:"mac"`
+	NetworkType  string            `json:"networkType" yaml:"networkType"`
+	OwnerAccount string            `json:"ownerAccountId" yaml:"ownerAccountId"`
+	PrivateIPv4  string            `json:"privateIpv4" yaml:"privateIpv4"`
+	EIPv4        string            `json:"eipv4" yaml:"eipv4"`
+	Region       string            `json:"region" yaml:"region"`
+	Zone         string            `json:"zone" yaml:"zone"`
+	SerialNumber string            `json:"serialNumber" yaml:"serialNumber"`
+	SourceAddr   string            `json:"sourceAddress" yaml:"sourceAddress"`
+	VpcID        string            `json:"vpcId" yaml:"vpcId"`
+	VpcCIDR      string            `json:"vpcCidrBlock" yaml:"vpcCidrBlock"`
+	VswitchCIDR   string            `json:"vswitchCidrBlock" yaml:"vswitchCidrBlock"`
+	VswitchByZone map[string]string `json:"vswitchIdByZone" yaml:"vswitchIdByZone"`
+	NTPServers    []string          `json:"ntpConfigServers" yaml:"ntpConfigServers"`
+	RoleName     string            `json:"roleName" yaml:"roleName"`
+	RamRoleToken metadata.RoleAuth `json:"ramRoleToken" yaml:"ramRoleToken"`
+}
+
+// fileMetaData serves every IMetaData accessor out of a document loaded
+// once from ALICLOUD_METADATA_FILE; an accessor whose field the document
+// leaves unset returns an error.
+type fileMetaData struct {
+	doc metadataDocument
+}
+
+func newFileMetaData() IMetaData {
+	m := &fileMetaData{}
+	path := os.Getenv(metadataFileEnvVar)
+	if path == "" {
+		glog.Warningf("alicloud: %s metadata provider selected but %s is not set, every accessor will return an error", metadataProviderFile, metadataFileEnvVar)
+		return m
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Errorf("alicloud: failed to read metadata file %s: %v", path, err)
+		return m
+	}
+	if err := yaml.Unmarshal(data, &m.doc); err != nil {
+		glog.Errorf("alicloud: failed to parse metadata file %s: %v", path, err)
+	}
+	return m
+}
+
+func (m *fileMetaData) HostName() (string, error)          { return nonEmpty(m.doc.HostName) }
+func (m *fileMetaData) ImageID() (string, error)            { return nonEmpty(m.doc.ImageID) }
+func (m *fileMetaData) InstanceID() (string, error)         { return nonEmpty(m.doc.InstanceID) }
+func (m *fileMetaData) Mac() (string, error)                { return nonEmpty(m.doc.Mac) }
+func (m *fileMetaData) NetworkType() (string, error)        { return nonEmpty(m.doc.NetworkType) }
+func (m *fileMetaData) OwnerAccountID() (string, error)     { return nonEmpty(m.doc.OwnerAccount) }
+func (m *fileMetaData) PrivateIPv4() (string, error)        { return nonEmpty(m.doc.PrivateIPv4) }
+func (m *fileMetaData) EIPv4() (string, error)              { return nonEmpty(m.doc.EIPv4) }
+func (m *fileMetaData) Region() (string, error)             { return nonEmpty(m.doc.Region) }
+func (m *fileMetaData) Zone() (string, error)               { return nonEmpty(m.doc.Zone) }
+func (m *fileMetaData) SerialNumber() (string, error)       { return nonEmpty(m.doc.SerialNumber) }
+func (m *fileMetaData) SourceAddress() (string, error)      { return nonEmpty(m.doc.SourceAddr) }
+func (m *fileMetaData) VpcCIDRBlock() (string, error)       { return nonEmpty(m.doc.VpcCIDR) }
+func (m *fileMetaData) VpcID() (string, error)              { return nonEmpty(m.doc.VpcID) }
+func (m *fileMetaData) VswitchCIDRBlock() (string, error)   { return nonEmpty(m.doc.VswitchCIDR) }
+func (m *fileMetaData) RoleName() (string, error)           { return nonEmpty(m.doc.RoleName) }
+
+func (m *fileMetaData) NTPConfigServers() ([]string, error) {
+	if len(m.doc.NTPServers) == 0 {
+		return nil, fmt.Errorf("alicloud: metadata file has no ntpConfigServers")
+	}
+	return m.doc.NTPServers, nil
+}
+
+func (m *fileMetaData) RamRoleToken(role string) (metadata.RoleAuth, error) {
+	if m.doc.RamRoleToken.AccessKeyId == "" {
+		return metadata.RoleAuth{}, fmt.Errorf("alicloud: metadata file has no ramRoleToken")
+	}
+	return m.doc.RamRoleToken, nil
+}
+
+// VswitchID looks up the document's zone in its vswitchIdByZone map and
+// returns an error when that zone has no entry.
+func (m *fileMetaData) VswitchID() (string, error) {
+	if vs, ok := m.doc.VswitchByZone[m.doc.Zone]; ok {
+		return vs, nil
+	}
+	return "", fmt.Errorf("alicloud: metadata file has no vswitch for zone %q", m.doc.Zone)
+}
+
+func nonEmpty(v string) (string, error) {
+	if v == "" {
+		return "", fmt.Errorf("alicloud: field not set in metadata document")
+	}
+	return v, nil
+}
+
+// envMetaData serves every IMetaData accessor out of ALICLOUD_META_* process
+// environment variables.
+type envMetaData struct{}
+
+func envField(suffix string) (string, error) {
+	key := "ALICLOUD_META_" + strings.ToUpper(suffix)
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("alicloud: environment variable %s not set", key)
+}
+
+func (m *envMetaData) HostName() (string, error)        { return envField("HOSTNAME") }
+func (m *envMetaData) ImageID() (string, error)          { return envField("IMAGE_ID") }
+func (m *envMetaData) InstanceID() (string, error)       { return envField("INSTANCE_ID") }
+func (m *envMetaData) Mac() (string, error)              { return envField("MAC") }
+func (m *envMetaData) NetworkType() (string, error)      { return envField("NETWORK_TYPE") }
+func (m *envMetaData) OwnerAccountID() (string, error)   { return envField("OWNER_ACCOUNT_ID") }
+func (m *envMetaData) PrivateIPv4() (string, error)      { return envField("PRIVATE_IPV4") }
+func (m *envMetaData) EIPv4() (string, error)            { return envField("EIPV4") }
+func (m *envMetaData) Region() (string, error)           { return envField("REGION") }
+func (m *envMetaData) Zone() (string, error)             { return envField("ZONE") }
+func (m *envMetaData) SerialNumber() (string, error)     { return envField("SERIAL_NUMBER") }
+func (m *envMetaData) SourceAddress() (string, error)    { return envField("SOURCE_ADDRESS") }
+func (m *envMetaData) VpcCIDRBlock() (string, error)     { return envField("VPC_CIDR_BLOCK") }
+func (m *envMetaData) VpcID() (string, error)            { return envField("VPC_ID") }
+func (m *envMetaData) VswitchCIDRBlock() (string, error) { return envField("VSWITCH_CIDR_BLOCK") }
+func (m *envMetaData) VswitchID() (string, error)        { return envField("VSWITCH_ID") }
+func (m *envMetaData) RoleName() (string, error)         { return envField("ROLE_NAME") }
+
+func (m *envMetaData) NTPConfigServers() ([]string, error) {
+	v, err := envField("NTP_CONFIG_SERVERS")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(v, ","), nil
+}
+
+func (m *envMetaData) RamRoleToken(role string) (metadata.RoleAuth, error) {
+	keyid, err := envField("RAM_ACCESS_KEY_ID")
+	if err != nil {
+		return metadata.RoleAuth{}, err
+	}
+	secret, err := envField("RAM_ACCESS_KEY_SECRET")
+	if err != nil {
+		return metadata.RoleAuth{}, err
+	}
+	token, _ := envField("RAM_SECURITY_TOKEN")
+	return metadata.RoleAuth{
+		AccessKeyId:     keyid,
+		AccessKeySecret: secret,
+		SecurityToken:   token,
+	}, nil
+}
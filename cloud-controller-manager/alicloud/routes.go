@@ -0,0 +1,32 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alicloud
+
+import (
+	"github.com/denverdino/aliyungo/ecs"
+	"github.com/patrickmn/go-cache"
+)
+
+// RoutesClient holds the raw ECS client together with caches for VPC and
+// router lookups. Nothing in this package reconciles routes with it;
+// Cloud.Routes reports routes as unsupported.
+type RoutesClient struct {
+	client *ecs.Client
+
+	routers *cache.Cache
+	vpcs    *cache.Cache
+}